@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// OrderBroker decouples order creation from however orders get fanned
+// out to the worker(s). channelBroker keeps the original in-process
+// behavior for tests; sqsBroker and redisStreamsBroker back onto real
+// infrastructure for multi-replica deployments.
+type OrderBroker interface {
+	Publish(ctx context.Context, order Order) error
+	Subscribe(ctx context.Context, handler func(Order) error) error
+	// Ping reports whether the broker's backing infrastructure is
+	// currently reachable, so handleReadyz can fail a replica out of
+	// rotation before its orders start backing up.
+	Ping(ctx context.Context) error
+}
+
+// activeBroker is the OrderBroker main() constructed, kept at package
+// scope so handleReadyz can reach it without threading it through every
+// handler.
+var activeBroker OrderBroker
+
+// newOrderBroker selects an implementation via the ORDER_BROKER env var
+// (one of "channel", "sqs", "redis"), defaulting to "channel".
+func newOrderBroker() OrderBroker {
+	switch os.Getenv("ORDER_BROKER") {
+	case "sqs":
+		return newSQSBroker()
+	case "redis":
+		return newRedisStreamsBroker()
+	default:
+		return newChannelBroker()
+	}
+}
+
+// channelBroker is the original chan-Order behavior, kept around because
+// it needs no external infrastructure; it is the default when
+// ORDER_BROKER is unset.
+type channelBroker struct {
+	queue chan Order
+}
+
+func newChannelBroker() *channelBroker {
+	return &channelBroker{queue: orderQueue}
+}
+
+func (b *channelBroker) Publish(ctx context.Context, order Order) error {
+	select {
+	case b.queue <- order:
+		return nil
+	default:
+		return fmt.Errorf("order queue is full")
+	}
+}
+
+// Ping always succeeds: the channel lives in this process, so if we're
+// running at all it's reachable.
+func (b *channelBroker) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (b *channelBroker) Subscribe(ctx context.Context, handler func(Order) error) error {
+	for {
+		select {
+		case order, ok := <-b.queue:
+			if !ok {
+				return nil
+			}
+			if err := handler(order); err != nil {
+				slog.Error("channelBroker handler error", "order_id", order.OrderID, "err", err)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sqsBroker publishes to a FIFO queue, using the order's EventID as the
+// MessageGroupID so that events for the same race stay ordered relative
+// to each other, and IdempotencyKey as the MessageDeduplicationId so SQS
+// itself collapses retried publishes.
+type sqsBroker struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+func newSQSBroker() *sqsBroker {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		log.Fatalf("failed to load AWS config for sqsBroker: %v", err)
+	}
+	return &sqsBroker{
+		client:   sqs.NewFromConfig(cfg),
+		queueURL: os.Getenv("ORDER_BROKER_SQS_QUEUE_URL"),
+	}
+}
+
+func (b *sqsBroker) Publish(ctx context.Context, order Order) error {
+	body, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	_, err = b.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(b.queueURL),
+		MessageBody:            aws.String(string(body)),
+		MessageGroupId:         aws.String(order.EventID),
+		MessageDeduplicationId: aws.String(order.IdempotencyKey),
+	})
+	return err
+}
+
+// Ping confirms the configured queue exists and is reachable.
+func (b *sqsBroker) Ping(ctx context.Context) error {
+	_, err := b.client.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(b.queueURL),
+		AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameQueueArn},
+	})
+	return err
+}
+
+func (b *sqsBroker) Subscribe(ctx context.Context, handler func(Order) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		out, err := b.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(b.queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			slog.Error("sqsBroker receive error", "err", err)
+			continue
+		}
+
+		for _, msg := range out.Messages {
+			var order Order
+			if err := json.Unmarshal([]byte(aws.ToString(msg.Body)), &order); err != nil {
+				slog.Error("sqsBroker failed to decode message", "err", err)
+				continue
+			}
+			if err := handler(order); err != nil {
+				slog.Error("sqsBroker handler error", "order_id", order.OrderID, "err", err)
+				continue
+			}
+			if _, err := b.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(b.queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			}); err != nil {
+				slog.Error("sqsBroker failed to delete message", "err", err)
+			}
+		}
+	}
+}
+
+// redisStreamsBroker gives at-least-once delivery via a consumer group,
+// reclaiming entries that were delivered but never acked (a consumer
+// died mid-processing).
+type redisStreamsBroker struct {
+	client   *redis.Client
+	stream   string
+	group    string
+	consumer string
+}
+
+func newRedisStreamsBroker() *redisStreamsBroker {
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("ORDER_BROKER_REDIS_ADDR")})
+	stream := "orders"
+	group := "order-workers"
+	if err := client.XGroupCreateMkStream(context.Background(), stream, group, "0").Err(); err != nil {
+		slog.Error("redisStreamsBroker consumer group create", "err", err)
+	}
+	return &redisStreamsBroker{
+		client:   client,
+		stream:   stream,
+		group:    group,
+		consumer: fmt.Sprintf("worker-%d", os.Getpid()),
+	}
+}
+
+func (b *redisStreamsBroker) Publish(ctx context.Context, order Order) error {
+	body, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.stream,
+		Values: map[string]interface{}{"order": body},
+	}).Err()
+}
+
+// Ping confirms the Redis connection backing the stream is reachable.
+func (b *redisStreamsBroker) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+func (b *redisStreamsBroker) Subscribe(ctx context.Context, handler func(Order) error) error {
+	go b.reclaimPending(ctx, handler)
+
+	for {
+		streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    b.group,
+			Consumer: b.consumer,
+			Streams:  []string{b.stream, ">"},
+			Count:    10,
+			Block:    5 * time.Second,
+		}).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err != redis.Nil {
+				slog.Error("redisStreamsBroker read error", "err", err)
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				b.handleMessage(ctx, msg, handler)
+			}
+		}
+	}
+}
+
+// reclaimPending periodically claims entries that were delivered to a
+// consumer that never acked them, so a crashed worker doesn't leak
+// orders.
+func (b *redisStreamsBroker) reclaimPending(ctx context.Context, handler func(Order) error) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			claimed, _, err := b.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   b.stream,
+				Group:    b.group,
+				Consumer: b.consumer,
+				MinIdle:  time.Minute,
+				Start:    "0",
+			}).Result()
+			if err != nil {
+				slog.Error("redisStreamsBroker autoclaim error", "err", err)
+				continue
+			}
+			for _, msg := range claimed {
+				b.handleMessage(ctx, msg, handler)
+			}
+		}
+	}
+}
+
+func (b *redisStreamsBroker) handleMessage(ctx context.Context, msg redis.XMessage, handler func(Order) error) {
+	raw, ok := msg.Values["order"].(string)
+	if !ok {
+		slog.Error("redisStreamsBroker message missing order field", "message_id", msg.ID)
+		return
+	}
+	var order Order
+	if err := json.Unmarshal([]byte(raw), &order); err != nil {
+		slog.Error("redisStreamsBroker failed to decode message", "message_id", msg.ID, "err", err)
+		return
+	}
+	if err := handler(order); err != nil {
+		slog.Error("redisStreamsBroker handler error", "order_id", order.OrderID, "err", err)
+		return
+	}
+	if err := b.client.XAck(ctx, b.stream, b.group, msg.ID).Err(); err != nil {
+		slog.Error("redisStreamsBroker failed to ack message", "message_id", msg.ID, "err", err)
+	}
+}
+
+// OutboxEvent records an order that still needs to be published to the
+// broker. It's written in the same transaction as the Order so that a
+// full or unreachable broker can never lose an order: the relay
+// goroutine retries until the broker acks.
+type OutboxEvent struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	OrderID      string     `gorm:"index" json:"order_id"`
+	Payload      []byte     `json:"-"`
+	Dispatched   bool       `gorm:"index" json:"dispatched"`
+	CreatedAt    time.Time  `json:"created_at"`
+	DispatchedAt *time.Time `json:"dispatched_at,omitempty"`
+}
+
+// relayOutbox polls for undispatched outbox rows and publishes them,
+// marking each dispatched only once the broker acks it.
+func relayOutbox(ctx context.Context, broker OrderBroker) {
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var pending []OutboxEvent
+			if err := db.Where("dispatched = ?", false).Order("id asc").Limit(100).Find(&pending).Error; err != nil {
+				slog.Error("outbox failed to load pending events", "err", err)
+				continue
+			}
+			for _, evt := range pending {
+				var order Order
+				if err := json.Unmarshal(evt.Payload, &order); err != nil {
+					slog.Error("outbox failed to decode payload", "outbox_event_id", evt.ID, "err", err)
+					continue
+				}
+
+				publishCtx, span := tracer.Start(ctx, "broker.publish")
+				err := broker.Publish(publishCtx, order)
+				span.End()
+				if err != nil {
+					slog.Warn("outbox publish failed, will retry", "order_id", order.OrderID, "err", err)
+					continue
+				}
+				now := time.Now()
+				if err := db.Model(&OutboxEvent{}).Where("id = ?", evt.ID).
+					Updates(map[string]interface{}{"dispatched": true, "dispatched_at": now}).Error; err != nil {
+					slog.Error("outbox failed to mark event dispatched", "outbox_event_id", evt.ID, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// enqueueOutbox inserts the OutboxEvent for order within an existing
+// transaction.
+func enqueueOutbox(tx *gorm.DB, order Order) error {
+	payload, err := json.Marshal(order)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&OutboxEvent{OrderID: order.OrderID, Payload: payload}).Error
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// recordingBroker is an in-memory OrderBroker stand-in so relayOutbox can
+// be exercised without real SQS/Redis infrastructure.
+type recordingBroker struct {
+	mu        sync.Mutex
+	published []Order
+}
+
+func (b *recordingBroker) Publish(ctx context.Context, order Order) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.published = append(b.published, order)
+	return nil
+}
+
+func (b *recordingBroker) Subscribe(ctx context.Context, handler func(Order) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *recordingBroker) Ping(ctx context.Context) error { return nil }
+
+func (b *recordingBroker) publishedOrders() []Order {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]Order, len(b.published))
+	copy(out, b.published)
+	return out
+}
+
+func TestRelayOutbox_PublishesAndMarksDispatched(t *testing.T) {
+	setupTestDB(t)
+
+	order := Order{OrderID: "order-1", EventID: "evt-1", Quantity: 1, CustomerEmail: "a@example.com", Status: StatusPending, CreatedAt: time.Now()}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatalf("failed to seed order: %v", err)
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return enqueueOutbox(tx, order)
+	}); err != nil {
+		t.Fatalf("failed to enqueue outbox event: %v", err)
+	}
+
+	broker := &recordingBroker{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go relayOutbox(ctx, broker)
+
+	deadline := time.After(3 * time.Second)
+	for {
+		if len(broker.publishedOrders()) > 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("timed out waiting for relayOutbox to publish the pending order")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	published := broker.publishedOrders()
+	if len(published) != 1 || published[0].OrderID != order.OrderID {
+		t.Fatalf("expected exactly one published order matching %s, got %+v", order.OrderID, published)
+	}
+
+	var evt OutboxEvent
+	if err := db.Where("order_id = ?", order.OrderID).First(&evt).Error; err != nil {
+		t.Fatalf("failed to reload outbox event: %v", err)
+	}
+	if !evt.Dispatched {
+		t.Fatal("expected outbox event to be marked dispatched")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OrderStatusEvent is the wire shape pushed to SSE/WS subscribers on
+// every state transition.
+type OrderStatusEvent struct {
+	ID            uint      `json:"id"`
+	OrderID       string    `json:"order_id"`
+	CustomerEmail string    `json:"customer_email"`
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	At            time.Time `json:"at"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+// subscriberBacklog bounds how many events a slow consumer can fall
+// behind by before it's dropped; beyond that we'd rather free the slot
+// than let one stalled client back up every transition.
+const subscriberBacklog = 64
+
+// OrderEventBus fans order status transitions out to SSE and WebSocket
+// subscribers. The worker publishes to it on every transition recorded
+// in payment.go; handlers subscribe with their own buffered channel.
+type OrderEventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan OrderStatusEvent]struct{}
+}
+
+var eventBus = newOrderEventBus()
+
+func newOrderEventBus() *OrderEventBus {
+	return &OrderEventBus{subscribers: make(map[chan OrderStatusEvent]struct{})}
+}
+
+// Subscribe registers a new buffered channel for the caller to read
+// events from. Call the returned func to unsubscribe.
+func (b *OrderEventBus) Subscribe() (chan OrderStatusEvent, func()) {
+	ch := make(chan OrderStatusEvent, subscriberBacklog)
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		// Publish may already have dropped and closed ch.
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish fans event out to every subscriber. A subscriber whose buffer
+// is already full is dropped rather than blocking the worker.
+func (b *OrderEventBus) Publish(event OrderStatusEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("OrderEventBus dropping slow subscriber", "backlog_limit", subscriberBacklog)
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// customerEmailFromBearerToken extracts the caller's customer_email from
+// a bearer token.
+//
+// KNOWN GAP, NOT AUTH: the "token" is taken as the customer's email
+// verbatim, with no signature or identity check — anyone who knows or
+// guesses a customer_email can read that customer's live order stream
+// over /ws/orders by sending it as the bearer token. This is a stand-in
+// for wiring in a real verifiable token (JWT, session lookup, etc.) and
+// must not be treated as an access control before that lands.
+func customerEmailFromBearerToken(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if !strings.HasPrefix(auth, prefix) {
+		return "", fmt.Errorf("missing bearer token")
+	}
+	email := strings.TrimPrefix(auth, prefix)
+	if email == "" {
+		return "", fmt.Errorf("empty bearer token")
+	}
+	return email, nil
+}
+
+// handleOrderEventsSSE streams status transitions for a single order as
+// Server-Sent Events. It supports resuming from Last-Event-ID by
+// replaying persisted events with a higher id before switching to live
+// updates.
+func handleOrderEventsSSE(w http.ResponseWriter, r *http.Request) {
+	orderID := strings.TrimPrefix(r.URL.Path, "/orders/")
+	orderID = strings.TrimSuffix(orderID, "/events")
+	if orderID == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	var lastID uint
+	if raw := r.Header.Get("Last-Event-ID"); raw != "" {
+		if parsed, err := strconv.ParseUint(raw, 10, 64); err == nil {
+			lastID = uint(parsed)
+		}
+	}
+
+	// Subscribe before reading the backlog so nothing published in the
+	// gap between the query and the subscribe call is lost; lastID is
+	// then used to dedupe whatever the live feed replays that the
+	// backlog already covered.
+	sub, unsubscribe := eventBus.Subscribe()
+	defer unsubscribe()
+
+	var backlog []OrderEvent
+	if err := db.Where("order_id = ? AND id > ?", orderID, lastID).Order("id asc").Find(&backlog).Error; err != nil {
+		slog.Error("failed to load SSE backlog", "order_id", orderID, "err", err)
+	}
+	for _, evt := range backlog {
+		if evt.ID > lastID {
+			lastID = evt.ID
+		}
+		writeSSEEvent(w, orderStatusEventFromOrderEvent(evt, orderID))
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if event.OrderID != orderID || event.ID <= lastID {
+				continue
+			}
+			lastID = event.ID
+			writeSSEEvent(w, event)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeSSEEvent(w http.ResponseWriter, event OrderStatusEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("failed to marshal SSE event", "err", err)
+		return
+	}
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.ID, body)
+}
+
+func orderStatusEventFromOrderEvent(evt OrderEvent, orderID string) OrderStatusEvent {
+	return OrderStatusEvent{
+		ID:      evt.ID,
+		OrderID: orderID,
+		From:    evt.From,
+		To:      evt.To,
+		At:      evt.At,
+		Reason:  evt.Reason,
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+// IdempotencyRecord stores the outcome of a previously handled order
+// request so that retries with the same Idempotency-Key return the
+// original response instead of creating a duplicate order.
+type IdempotencyRecord struct {
+	ID             uint   `gorm:"primaryKey" json:"-"`
+	CustomerEmail  string `gorm:"uniqueIndex:idx_idem_key" json:"customer_email"`
+	IdempotencyKey string `gorm:"uniqueIndex:idx_idem_key" json:"idempotency_key"`
+	Fingerprint    string `json:"fingerprint"`
+	OrderID        string `json:"order_id"`
+	ResponseBody   []byte `json:"-"`
+	ResponseStatus int    `json:"-"`
+}
+
+// fingerprintOrderRequest returns a stable SHA-256 hash of the
+// canonicalized request so that two requests sent under the same
+// idempotency key can be compared for equality.
+func fingerprintOrderRequest(req OrderRequest) (string, error) {
+	canonical, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// idempotencyKeyFromRequest reads the client-supplied key from the
+// Idempotency-Key header, falling back to the body field if the header
+// is absent.
+func idempotencyKeyFromRequest(r *http.Request, req OrderRequest) string {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	return req.IdempotencyKey
+}
+
+// writeStoredResponse replays a previously persisted response verbatim.
+func writeStoredResponse(w http.ResponseWriter, rec *IdempotencyRecord) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(rec.ResponseStatus)
+	w.Write(rec.ResponseBody)
+}
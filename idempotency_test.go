@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func postOrder(t *testing.T, req OrderRequest, idempotencyKey string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("failed to marshal order request: %v", err)
+	}
+	httpReq := httptest.NewRequest("POST", "/orders", bytes.NewReader(body))
+	if idempotencyKey != "" {
+		httpReq.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	rec := httptest.NewRecorder()
+	handleCreateOrder(rec, httpReq)
+	return rec
+}
+
+func decodeOrderID(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var resp map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response body %q: %v", rec.Body.String(), err)
+	}
+	return resp["order_id"]
+}
+
+func TestHandleCreateOrder_IdenticalReplay(t *testing.T) {
+	setupTestDB(t)
+	insertTestEvent(t, Event{
+		EventID:      "evt-1",
+		TotalSeats:   100,
+		SaleOpensAt:  time.Now().Add(-time.Hour),
+		SaleClosesAt: time.Now().Add(time.Hour),
+	})
+
+	req := OrderRequest{EventID: "evt-1", Quantity: 2, CustomerEmail: "a@example.com", PaymentToken: "tok_visa"}
+
+	first := postOrder(t, req, "key-1")
+	if first.Code != 202 {
+		t.Fatalf("expected first request to return 202, got %d: %s", first.Code, first.Body.String())
+	}
+	firstOrderID := decodeOrderID(t, first)
+
+	second := postOrder(t, req, "key-1")
+	if second.Code != first.Code {
+		t.Fatalf("replay status code %d did not match original %d", second.Code, first.Code)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Fatalf("replay body %q did not match original %q", second.Body.String(), first.Body.String())
+	}
+
+	var count int64
+	if err := db.Model(&Order{}).Where("order_id = ?", firstOrderID).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count orders: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected exactly one order to be persisted, got %d", count)
+	}
+}
+
+func TestHandleCreateOrder_ConflictingReplay(t *testing.T) {
+	setupTestDB(t)
+	insertTestEvent(t, Event{
+		EventID:      "evt-1",
+		TotalSeats:   100,
+		SaleOpensAt:  time.Now().Add(-time.Hour),
+		SaleClosesAt: time.Now().Add(time.Hour),
+	})
+
+	first := postOrder(t, OrderRequest{EventID: "evt-1", Quantity: 2, CustomerEmail: "a@example.com", PaymentToken: "tok_visa"}, "key-1")
+	if first.Code != 202 {
+		t.Fatalf("expected first request to return 202, got %d: %s", first.Code, first.Body.String())
+	}
+
+	conflicting := postOrder(t, OrderRequest{EventID: "evt-1", Quantity: 3, CustomerEmail: "a@example.com", PaymentToken: "tok_visa"}, "key-1")
+	if conflicting.Code != 422 {
+		t.Fatalf("expected conflicting replay to return 422, got %d: %s", conflicting.Code, conflicting.Body.String())
+	}
+
+	var resp APIError
+	if err := json.Unmarshal(conflicting.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode conflict response: %v", err)
+	}
+	if resp.Error != "key_conflict" {
+		t.Fatalf("expected key_conflict error, got %q", resp.Error)
+	}
+}
+
+func TestHandleCreateOrder_ConcurrentReplay(t *testing.T) {
+	setupTestDB(t)
+	insertTestEvent(t, Event{
+		EventID:      "evt-1",
+		TotalSeats:   100,
+		SaleOpensAt:  time.Now().Add(-time.Hour),
+		SaleClosesAt: time.Now().Add(time.Hour),
+	})
+
+	req := OrderRequest{EventID: "evt-1", Quantity: 2, CustomerEmail: "a@example.com", PaymentToken: "tok_visa"}
+
+	const concurrency = 10
+	var wg sync.WaitGroup
+	orderIDs := make([]string, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := postOrder(t, req, "key-1")
+			if rec.Code != 202 {
+				t.Errorf("concurrent replay %d expected 202, got %d: %s", i, rec.Code, rec.Body.String())
+				return
+			}
+			orderIDs[i] = decodeOrderID(t, rec)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < concurrency; i++ {
+		if orderIDs[i] != orderIDs[0] {
+			t.Fatalf("concurrent replays disagreed on order_id: %q vs %q", orderIDs[0], orderIDs[i])
+		}
+	}
+
+	var count int64
+	if err := db.Model(&Order{}).Where("order_id = ?", orderIDs[0]).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count orders: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("expected exactly one order despite %d concurrent identical requests, got %d", concurrency, count)
+	}
+}
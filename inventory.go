@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// reservationTTL is how long an unpaid reservation holds its seats
+// before the sweeper releases them back to the pool.
+const reservationTTL = 10 * time.Minute
+
+// Event describes a race's ticket inventory and sale window.
+type Event struct {
+	EventID          string    `gorm:"primaryKey" json:"event_id"`
+	TotalSeats       int       `json:"total_seats"`
+	Sold             int       `json:"sold"`
+	Reserved         int       `json:"reserved"`
+	SaleOpensAt      time.Time `json:"sale_opens_at"`
+	SaleClosesAt     time.Time `json:"sale_closes_at"`
+	PerCustomerLimit int       `json:"per_customer_limit"`
+	// AdmissionRPSOverride raises (or lowers) this event's per-event_id
+	// admission gate above RateLimitConfig.PerEventRPS; zero means use
+	// the global default. See ratelimit.go.
+	AdmissionRPSOverride float64 `json:"admission_rps_override,omitempty"`
+}
+
+// Reservation tracks a seat hold made for an order while it moves
+// through the payment saga. Reservations that are never paid expire
+// after reservationTTL and are released by the sweeper.
+type Reservation struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	OrderID       string    `gorm:"uniqueIndex" json:"order_id"`
+	EventID       string    `gorm:"index" json:"event_id"`
+	CustomerEmail string    `json:"customer_email"`
+	Quantity      int       `json:"quantity"`
+	Released      bool      `gorm:"index" json:"released"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+var (
+	errSaleNotOpen      = errors.New("ticket sale is not currently open for this event")
+	errPerCustomerLimit = errors.New("order would exceed the per-customer ticket limit for this event")
+	errEventNotFound    = errors.New("event not found")
+)
+
+// validateEventAvailability rejects orders placed outside the sale
+// window or that would push a customer over their per-event limit,
+// summed across that customer's prior orders for the event.
+func validateEventAvailability(req OrderRequest) error {
+	var event Event
+	if err := db.Where("event_id = ?", req.EventID).First(&event).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errEventNotFound
+		}
+		return err
+	}
+
+	now := time.Now()
+	if now.Before(event.SaleOpensAt) || now.After(event.SaleClosesAt) {
+		return errSaleNotOpen
+	}
+
+	if event.PerCustomerLimit > 0 {
+		var priorQuantity int64
+		err := db.Model(&Order{}).
+			Where("event_id = ? AND customer_email = ? AND status NOT IN ?", req.EventID, req.CustomerEmail,
+				[]string{StatusPaymentFailed, StatusRejectedSoldOut, StatusRefunded}).
+			Select("COALESCE(SUM(quantity), 0)").Scan(&priorQuantity).Error
+		if err != nil {
+			return err
+		}
+		if int(priorQuantity)+req.Quantity > event.PerCustomerLimit {
+			return errPerCustomerLimit
+		}
+	}
+
+	return nil
+}
+
+// reserveSeats atomically claims quantity seats for order, returning
+// false if the event is sold out. The WHERE clause's availability check
+// and the UPDATE happen in one statement, so concurrent bookings can't
+// both observe capacity and both reserve it. The UPDATE and the
+// Reservation row it backs are written in one transaction so a crash
+// between the two can never leave a `reserved` count with no
+// corresponding Reservation to release it.
+func reserveSeats(orderID, eventID, customerEmail string, quantity int) (bool, error) {
+	var reserved bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec(
+			`UPDATE events SET reserved = reserved + ? WHERE event_id = ? AND (total_seats - sold - reserved) >= ?`,
+			quantity, eventID, quantity,
+		)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			inventoryReservationsTotal.WithLabelValues("sold_out").Inc()
+			return nil
+		}
+
+		reservation := Reservation{
+			OrderID:       orderID,
+			EventID:       eventID,
+			CustomerEmail: customerEmail,
+			Quantity:      quantity,
+			ExpiresAt:     time.Now().Add(reservationTTL),
+		}
+		if err := tx.Create(&reservation).Error; err != nil {
+			return err
+		}
+		inventoryReservationsTotal.WithLabelValues("reserved").Inc()
+		reserved = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return reserved, nil
+}
+
+// releaseReservation returns a reservation's seats to the pool when the
+// order is abandoned (charge failure or TTL expiry). A paid order goes
+// through confirmSale instead, which moves the seats to `sold`.
+func releaseReservation(res Reservation) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Reservation{}).
+			Where("id = ? AND released = ?", res.ID, false).
+			Update("released", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil // already released by someone else
+		}
+		return tx.Exec(`UPDATE events SET reserved = reserved - ? WHERE event_id = ?`, res.Quantity, res.EventID).Error
+	})
+}
+
+// confirmSale converts a held reservation into a sale: the seats move
+// from `reserved` to `sold` so they never re-enter the pool.
+func confirmSale(res Reservation) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Reservation{}).
+			Where("id = ? AND released = ?", res.ID, false).
+			Update("released", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+		return tx.Exec(`UPDATE events SET reserved = reserved - ?, sold = sold + ? WHERE event_id = ?`,
+			res.Quantity, res.Quantity, res.EventID).Error
+	})
+}
+
+// sweepExpiredReservations releases any unreleased reservation past its
+// TTL, running in a loop until the caller's context is cancelled.
+func sweepExpiredReservations(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var expired []Reservation
+			err := db.Where("released = ? AND expires_at < ?", false, time.Now()).Find(&expired).Error
+			if err != nil {
+				slog.Error("sweeper failed to load expired reservations", "err", err)
+				continue
+			}
+			for _, res := range expired {
+				if err := releaseReservation(res); err != nil {
+					slog.Error("sweeper failed to release reservation", "reservation_id", res.ID, "order_id", res.OrderID, "err", err)
+					continue
+				}
+				slog.Info("sweeper released expired reservation", "reservation_id", res.ID, "order_id", res.OrderID)
+			}
+		}
+	}
+}
+
+// GET /events/{id}/availability
+func handleEventAvailability(w http.ResponseWriter, r *http.Request) {
+	eventID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/events/"), "/availability")
+	if eventID == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var event Event
+	if err := db.Where("event_id = ?", eventID).First(&event).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondJSON(w, http.StatusNotFound, APIError{"not_found", "event not found"})
+			return
+		}
+		slog.Error("failed to look up event", "event_id", eventID, "err", err, traceAttr(r.Context()))
+		respondJSON(w, http.StatusInternalServerError, APIError{"db_error", "failed to load event"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"event_id":    event.EventID,
+		"total_seats": event.TotalSeats,
+		"sold":        event.Sold,
+		"reserved":    event.Reserved,
+		"available":   event.TotalSeats - event.Sold - event.Reserved,
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReserveSeats_AtomicUnderConcurrency(t *testing.T) {
+	setupTestDB(t)
+
+	const totalSeats = 10
+	const attempts = 30
+	insertTestEvent(t, Event{
+		EventID:      "evt-concurrency",
+		TotalSeats:   totalSeats,
+		SaleOpensAt:  time.Now().Add(-time.Hour),
+		SaleClosesAt: time.Now().Add(time.Hour),
+	})
+
+	var wg sync.WaitGroup
+	results := make([]bool, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reserved, err := reserveSeats(fmt.Sprintf("order-%d", i), "evt-concurrency", "a@example.com", 1)
+			if err != nil {
+				t.Errorf("reserveSeats returned error: %v", err)
+				return
+			}
+			results[i] = reserved
+		}(i)
+	}
+	wg.Wait()
+
+	var succeeded int
+	for _, ok := range results {
+		if ok {
+			succeeded++
+		}
+	}
+	if succeeded != totalSeats {
+		t.Fatalf("expected exactly %d successful reservations out of %d attempts, got %d", totalSeats, attempts, succeeded)
+	}
+
+	var event Event
+	if err := db.Where("event_id = ?", "evt-concurrency").First(&event).Error; err != nil {
+		t.Fatalf("failed to reload event: %v", err)
+	}
+	if event.Reserved != totalSeats {
+		t.Fatalf("expected event.Reserved to be %d, got %d", totalSeats, event.Reserved)
+	}
+
+	var reservationCount int64
+	if err := db.Model(&Reservation{}).Where("event_id = ?", "evt-concurrency").Count(&reservationCount).Error; err != nil {
+		t.Fatalf("failed to count reservations: %v", err)
+	}
+	if reservationCount != int64(totalSeats) {
+		t.Fatalf("expected %d reservation rows matching the %d successful reserveSeats calls, got %d", totalSeats, totalSeats, reservationCount)
+	}
+}
+
+func TestReserveSeats_SoldOut(t *testing.T) {
+	setupTestDB(t)
+
+	insertTestEvent(t, Event{
+		EventID:      "evt-full",
+		TotalSeats:   1,
+		Sold:         1,
+		SaleOpensAt:  time.Now().Add(-time.Hour),
+		SaleClosesAt: time.Now().Add(time.Hour),
+	})
+
+	reserved, err := reserveSeats("order-1", "evt-full", "a@example.com", 1)
+	if err != nil {
+		t.Fatalf("reserveSeats returned error: %v", err)
+	}
+	if reserved {
+		t.Fatal("expected reserveSeats to fail for a sold-out event")
+	}
+
+	var reservationCount int64
+	if err := db.Model(&Reservation{}).Where("event_id = ?", "evt-full").Count(&reservationCount).Error; err != nil {
+		t.Fatalf("failed to count reservations: %v", err)
+	}
+	if reservationCount != 0 {
+		t.Fatalf("expected no reservation row for a sold-out attempt, got %d", reservationCount)
+	}
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"log"
-	"math/rand"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	otelgorm "gorm.io/plugin/opentelemetry/tracing"
 
 	"github.com/google/uuid"
 )
@@ -22,7 +29,10 @@ func initDB() {
 	if err != nil {
 		log.Fatalf("failed to connect database: %v", err)
 	}
-	db.AutoMigrate(&Order{}) // auto-create table
+	if err := db.Use(otelgorm.NewPlugin()); err != nil {
+		log.Fatalf("failed to install GORM tracing plugin: %v", err)
+	}
+	db.AutoMigrate(&Order{}, &IdempotencyRecord{}, &OrderEvent{}, &OutboxEvent{}, &Event{}, &Reservation{}) // auto-create tables
 }
 
 // Order represents a ticket order submitted by a client
@@ -37,9 +47,9 @@ type Order struct {
 	CreatedAt      time.Time `json:"created_at"`
 }
 
-// In-memory store to simulate persistence
 var (
-	// Simulated queue (later replaced by SQS/PubSub)
+	// orderQueue backs channelBroker, the default OrderBroker used in
+	// single-replica deployments; see broker.go.
 	orderQueue = make(chan Order, 10000)
 )
 
@@ -49,6 +59,9 @@ type OrderRequest struct {
 	Quantity      int    `json:"quantity"`
 	CustomerEmail string `json:"customer_email"`
 	PaymentToken  string `json:"payment_token"`
+	// IdempotencyKey is only used when the client doesn't set the
+	// Idempotency-Key header.
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 type APIError struct {
@@ -96,64 +109,254 @@ func handleCreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Generate unique IDs
-	orderID := uuid.New().String()
-	idemKey := uuid.New().String()
+	idemKey := idempotencyKeyFromRequest(r, req)
+	if idemKey == "" {
+		idemKey = uuid.New().String()
+	}
+
+	fingerprint, err := fingerprintOrderRequest(req)
+	if err != nil {
+		slog.Error("failed to fingerprint order request", "idempotency_key", idemKey, "err", err, traceAttr(r.Context()))
+		respondJSON(w, http.StatusInternalServerError, APIError{"internal_error", "failed to process request"})
+		return
+	}
+
+	// Look for a prior record under this (customer, key) pair before
+	// doing any other work — including event-availability validation —
+	// so a pure replay always gets the original response back instead of
+	// being re-evaluated against the event's current sale window or
+	// per-customer limit, which may have changed since the first attempt.
+	var existing IdempotencyRecord
+	err = db.Where("customer_email = ? AND idempotency_key = ?", req.CustomerEmail, idemKey).
+		First(&existing).Error
+	switch {
+	case err == nil:
+		if existing.Fingerprint != fingerprint {
+			respondJSON(w, http.StatusUnprocessableEntity, APIError{"key_conflict", "idempotency key was already used with a different request"})
+			return
+		}
+		writeStoredResponse(w, &existing)
+		return
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		slog.Error("failed to look up idempotency record", "idempotency_key", idemKey, "err", err, traceAttr(r.Context()))
+		respondJSON(w, http.StatusInternalServerError, APIError{"db_error", "failed to process request"})
+		return
+	}
+
+	if err := validateEventAvailability(req); err != nil {
+		switch {
+		case errors.Is(err, errEventNotFound):
+			respondJSON(w, http.StatusNotFound, APIError{"not_found", err.Error()})
+		case errors.Is(err, errSaleNotOpen), errors.Is(err, errPerCustomerLimit):
+			respondJSON(w, http.StatusBadRequest, APIError{"validation_error", err.Error()})
+		default:
+			slog.Error("failed to validate event availability", "event_id", req.EventID, "err", err, traceAttr(r.Context()))
+			respondJSON(w, http.StatusInternalServerError, APIError{"db_error", "failed to validate event"})
+		}
+		return
+	}
 
+	orderID := uuid.New().String()
 	order := Order{
 		OrderID:        orderID,
 		EventID:        req.EventID,
 		Quantity:       req.Quantity,
 		CustomerEmail:  req.CustomerEmail,
 		PaymentToken:   req.PaymentToken,
-		Status:         "PENDING",
+		Status:         StatusPending,
 		IdempotencyKey: idemKey,
 		CreatedAt:      time.Now(),
 	}
 
-	// Persist to store
-	if err := db.Create(&order).Error; err != nil {
-		log.Printf("failed to persist order: %v", err)
-		respondJSON(w, http.StatusInternalServerError, APIError{"db_error", "failed to save order"})
+	responseBody, err := json.Marshal(map[string]string{
+		"order_id":        orderID,
+		"idempotency_key": idemKey,
+		"status":          StatusPending,
+	})
+	if err != nil {
+		slog.Error("failed to marshal order response", "order_id", orderID, "err", err, traceAttr(r.Context()))
+		respondJSON(w, http.StatusInternalServerError, APIError{"internal_error", "failed to process request"})
 		return
 	}
 
-	// Publish to queue (async processing will handle inventory + payment)
-	select {
-	case orderQueue <- order:
-		log.Printf("Enqueued order %s", orderID)
-	default:
-		log.Printf("Queue full, dropping order %s", orderID)
-		http.Error(w, "system overloaded", http.StatusServiceUnavailable)
+	// Insert the order and its idempotency record atomically: the
+	// unique index on (customer_email, idempotency_key) makes
+	// concurrent retries resolve deterministically — exactly one
+	// transaction wins, the rest see a unique-constraint error and
+	// fall back to replaying the winner's response.
+	txErr := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&order).Error; err != nil {
+			return err
+		}
+		record := IdempotencyRecord{
+			CustomerEmail:  req.CustomerEmail,
+			IdempotencyKey: idemKey,
+			Fingerprint:    fingerprint,
+			OrderID:        orderID,
+			ResponseBody:   responseBody,
+			ResponseStatus: http.StatusAccepted,
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		// Outbox row lives in the same transaction as the order, so a
+		// full or unreachable broker can never cause a lost order; the
+		// relay goroutine publishes it once the broker is available.
+		return enqueueOutbox(tx, order)
+	})
+	if txErr != nil {
+		// Someone else's concurrent retry won the race on the unique
+		// index; replay whatever they persisted.
+		var winner IdempotencyRecord
+		if err := db.Where("customer_email = ? AND idempotency_key = ?", req.CustomerEmail, idemKey).
+			First(&winner).Error; err == nil {
+			if winner.Fingerprint != fingerprint {
+				respondJSON(w, http.StatusUnprocessableEntity, APIError{"key_conflict", "idempotency key was already used with a different request"})
+				return
+			}
+			writeStoredResponse(w, &winner)
+			return
+		}
+		slog.Error("failed to persist order", "order_id", orderID, "err", txErr, traceAttr(r.Context()))
+		respondJSON(w, http.StatusInternalServerError, APIError{"db_error", "failed to save order"})
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(map[string]string{
-		"order_id":        orderID,
-		"idempotency_key": idemKey,
-		"status":          "PENDING",
+	ordersCreatedTotal.WithLabelValues(StatusPending).Inc()
+	writeStoredResponse(w, &IdempotencyRecord{ResponseBody: responseBody, ResponseStatus: http.StatusAccepted})
+}
+
+// Background worker that drives each order through the reserve -> charge
+// saga defined in payment.go. Orders arrive via whichever OrderBroker is
+// configured, having been handed off through the outbox relay.
+func startWorker(ctx context.Context, broker OrderBroker) {
+	err := broker.Subscribe(ctx, func(order Order) error {
+		ctx, span := tracer.Start(ctx, "worker.process_order")
+		defer span.End()
+		slog.Info("processing order", "order_id", order.OrderID, "event_id", order.EventID, traceAttr(ctx))
+		start := time.Now()
+		processOrder(order)
+		orderProcessingSeconds.Observe(time.Since(start).Seconds())
+		return nil
 	})
+	if err != nil && ctx.Err() == nil {
+		slog.Error("worker subscribe loop exited", "err", err)
+	}
 }
 
-// Background worker simulating async processing (here just logging)
-func startWorker() {
-	for order := range orderQueue {
-		// Simulate variable processing time
-		time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
-		log.Printf("[Worker] Processing order %s for event %s", order.OrderID, order.EventID)
-		// Later: decrement inventory + charge payment
+// handleOrderOrEventsRoute dispatches the "/orders/" prefix between the
+// plain order lookup and its SSE event stream, since net/http's
+// ServeMux only matches by prefix here.
+func handleOrderOrEventsRoute(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/events") {
+		handleOrderEventsSSE(w, r)
+		return
+	}
+	handleGetOrder(w, r)
+}
+
+// GET /orders/{id} handler: returns the order's current status plus its
+// full transition history so clients can poll instead of needing a push
+// channel.
+func handleGetOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := strings.TrimPrefix(r.URL.Path, "/orders/")
+	if orderID == "" || orderID == r.URL.Path {
+		http.NotFound(w, r)
+		return
+	}
+
+	var order Order
+	if err := db.Where("order_id = ?", orderID).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondJSON(w, http.StatusNotFound, APIError{"not_found", "order not found"})
+			return
+		}
+		slog.Error("failed to look up order", "order_id", orderID, "err", err, traceAttr(r.Context()))
+		respondJSON(w, http.StatusInternalServerError, APIError{"db_error", "failed to load order"})
+		return
+	}
+
+	var events []OrderEvent
+	if err := db.Where("order_id = ?", orderID).Order("at asc").Find(&events).Error; err != nil {
+		slog.Error("failed to load order events", "order_id", orderID, "err", err, traceAttr(r.Context()))
+		respondJSON(w, http.StatusInternalServerError, APIError{"db_error", "failed to load order history"})
+		return
 	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"order":  order,
+		"events": events,
+	})
 }
 
 func main() {
+	initLogging()
 	initDB()
-	go startWorker()
 
-	http.HandleFunc("/orders", handleCreateOrder)
-	log.Println("Listening on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shutdownTracer, err := initTracer(ctx)
+	if err != nil {
+		slog.Error("failed to initialize tracing, continuing without it", "err", err)
+		shutdownTracer = func(context.Context) error { return nil }
+	}
+
+	broker := newOrderBroker()
+	activeBroker = broker
+
+	// drainWg tracks every background goroutine that must finish its
+	// current unit of work before the process exits, so shutdown can
+	// actually wait for them instead of just cancelling their context
+	// and hoping.
+	var drainWg sync.WaitGroup
+	runDraining := func(fn func(context.Context)) {
+		drainWg.Add(1)
+		go func() {
+			defer drainWg.Done()
+			fn(ctx)
+		}()
+	}
+	runDraining(func(ctx context.Context) { relayOutbox(ctx, broker) })
+	runDraining(func(ctx context.Context) { startWorker(ctx, broker) })
+	runDraining(sweepExpiredReservations)
+	runDraining(reportQueueDepth)
+
+	http.Handle("/orders", instrumentHandler("create_order", rateLimitMiddleware(handleCreateOrder)))
+	http.Handle("/orders/", instrumentHandler("order_route", handleOrderOrEventsRoute))
+	http.HandleFunc("/ws/orders", handleOrdersWebSocket)
+	http.Handle("/events/", instrumentHandler("event_availability", handleEventAvailability))
+	registerMetricsAndHealthRoutes()
+
+	server := &http.Server{Addr: ":8080"}
+
+	go func() {
+		slog.Info("listening", "addr", server.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("server stopped unexpectedly", "err", err)
+		}
+	}()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
+	<-sigCh
+	slog.Info("shutdown signal received, draining")
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer shutdownCancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server shutdown error", "err", err)
+	}
+
+	// Stop accepting new work, then wait for the background goroutines to
+	// actually observe ctx.Done() and return before the process exits,
+	// instead of racing them.
+	cancel()
+	drainWg.Wait()
+	close(orderQueue)
+
+	if err := shutdownTracer(shutdownCtx); err != nil {
+		slog.Error("tracer shutdown error", "err", err)
 	}
 }
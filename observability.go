@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is used for the application-level spans this file and the
+// worker/broker add on top of the otelhttp/GORM instrumentation.
+var tracer = otel.Tracer("go-street-races-api")
+
+// initLogging switches the standard logger's output to structured JSON
+// via log/slog, and makes slog.Default() (used everywhere else in the
+// codebase) match it, so every log line gets consistent request-scoped
+// attributes (order_id, event_id, idempotency_key, trace_id) instead of
+// ad-hoc Printf strings.
+func initLogging() {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})
+	slog.SetDefault(slog.New(handler))
+}
+
+// traceAttr returns the slog attribute for the span active on ctx, if
+// any, so log lines can be correlated with traces.
+func traceAttr(ctx context.Context) slog.Attr {
+	span := trace.SpanFromContext(ctx)
+	if !span.SpanContext().IsValid() {
+		return slog.String("trace_id", "")
+	}
+	return slog.String("trace_id", span.SpanContext().TraceID().String())
+}
+
+// initTracer wires up an OpenTelemetry TracerProvider exporting spans
+// via OTLP/HTTP, propagated via the standard W3C traceparent header.
+// The returned func flushes and shuts the provider down; call it from
+// main's shutdown path.
+func initTracer(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceName("go-street-races-api"),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// instrumentHandler wraps an HTTP handler with OpenTelemetry span
+// creation/propagation for the given route name.
+func instrumentHandler(name string, handler http.HandlerFunc) http.Handler {
+	return otelhttp.NewHandler(handler, name)
+}
+
+// Prometheus collectors. Registered on the default registry so a single
+// promhttp.Handler() on /metrics exposes all of them.
+var (
+	ordersCreatedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "orders_created_total",
+		Help: "Total number of times an order entered each status (PENDING on creation, then once per recorded transition).",
+	}, []string{"status"})
+
+	orderProcessingSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "order_processing_seconds",
+		Help:    "Time the worker spends running one order through the reserve/charge saga, from pickup off the broker to return.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	queueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "queue_depth",
+		Help: "Current depth of the in-process order channel (channelBroker only).",
+	})
+
+	inventoryReservationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "inventory_reservations_total",
+		Help: "Total number of reservation attempts, by outcome (reserved, sold_out).",
+	}, []string{"outcome"})
+
+	paymentLatencySeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "payment_latency_seconds",
+		Help:    "Latency of the payment provider charge call, by provider.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider"})
+)
+
+// reportQueueDepth polls the channelBroker's backing channel so the
+// queue_depth gauge stays current; it's a no-op under the SQS/Redis
+// brokers, which have no local queue to sample.
+func reportQueueDepth(ctx context.Context) {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			queueDepth.Set(float64(len(orderQueue)))
+		}
+	}
+}
+
+// handleHealthz is the liveness probe: if the process can answer HTTP
+// at all, it's alive.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz is the readiness probe: it additionally checks the
+// dependencies a request actually needs (DB, broker) so orchestrators
+// can drain the pod before those start failing requests.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		respondJSON(w, http.StatusServiceUnavailable, APIError{"not_ready", "db unavailable: " + err.Error()})
+		return
+	}
+	if err := sqlDB.PingContext(r.Context()); err != nil {
+		respondJSON(w, http.StatusServiceUnavailable, APIError{"not_ready", "db ping failed: " + err.Error()})
+		return
+	}
+	if activeBroker != nil {
+		if err := activeBroker.Ping(r.Context()); err != nil {
+			respondJSON(w, http.StatusServiceUnavailable, APIError{"not_ready", "broker unavailable: " + err.Error()})
+			return
+		}
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+func registerMetricsAndHealthRoutes() {
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/stripe/stripe-go/v76"
+	"github.com/stripe/stripe-go/v76/paymentintent"
+	"gorm.io/gorm"
+)
+
+// Order status values. The worker drives an order through this state
+// machine; REJECTED_SOLD_OUT, PAYMENT_FAILED and REFUNDED are
+// compensating transitions that can be reached instead of the happy path.
+const (
+	StatusPending         = "PENDING"
+	StatusReserved        = "RESERVED"
+	StatusCharging        = "CHARGING"
+	StatusPaid            = "PAID"
+	StatusPaymentFailed   = "PAYMENT_FAILED"
+	StatusRefunded        = "REFUNDED"
+	StatusRejectedSoldOut = "REJECTED_SOLD_OUT"
+)
+
+// OrderEvent is an append-only record of every state transition an order
+// goes through, so `GET /orders/{id}` can return a full audit trail and
+// clients can poll instead of needing a push channel.
+type OrderEvent struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	OrderID     string    `gorm:"index" json:"order_id"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Reason      string    `json:"reason,omitempty"`
+	ProviderRef string    `json:"provider_ref,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// recordTransition appends an OrderEvent and updates the order's current
+// status in a single transaction, then publishes the transition to the
+// OrderEventBus so SSE/WebSocket subscribers see it in real time.
+func recordTransition(orderID, customerEmail, from, to, reason, providerRef string) error {
+	event := OrderEvent{
+		OrderID:     orderID,
+		From:        from,
+		To:          to,
+		Reason:      reason,
+		ProviderRef: providerRef,
+		At:          time.Now(),
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Order{}).Where("order_id = ?", orderID).Update("status", to).Error
+	})
+	if err != nil {
+		return err
+	}
+	ordersCreatedTotal.WithLabelValues(to).Inc()
+
+	eventBus.Publish(OrderStatusEvent{
+		ID:            event.ID,
+		OrderID:       orderID,
+		CustomerEmail: customerEmail,
+		From:          from,
+		To:            to,
+		At:            event.At,
+		Reason:        reason,
+	})
+	return nil
+}
+
+// chargeOrder creates a Stripe PaymentIntent for the order's payment
+// token and confirms it, returning the PaymentIntent id for use as the
+// event's provider_ref.
+func chargeOrder(order Order) (string, error) {
+	params := &stripe.PaymentIntentParams{
+		Amount:        stripe.Int64(int64(order.Quantity) * ticketPriceCents),
+		Currency:      stripe.String(string(stripe.CurrencyUSD)),
+		PaymentMethod: stripe.String(order.PaymentToken),
+		Confirm:       stripe.Bool(true),
+		Description:   stripe.String(fmt.Sprintf("order %s (event %s)", order.OrderID, order.EventID)),
+	}
+	start := time.Now()
+	pi, err := paymentintent.New(params)
+	paymentLatencySeconds.WithLabelValues("stripe").Observe(time.Since(start).Seconds())
+	if err != nil {
+		return "", err
+	}
+	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+		return pi.ID, fmt.Errorf("payment intent %s did not succeed: status=%s", pi.ID, pi.Status)
+	}
+	return pi.ID, nil
+}
+
+// ticketPriceCents is a placeholder flat price until the Event model
+// carries its own pricing.
+const ticketPriceCents = 2500
+
+// processOrder runs a single order through the reserve -> charge saga,
+// recording every transition. A sold-out event rejects the order via a
+// compensating REJECTED_SOLD_OUT event instead of reserving; a charge
+// failure releases the reservation via a compensating PAYMENT_FAILED
+// event.
+func processOrder(order Order) {
+	reserved, err := reserveSeats(order.OrderID, order.EventID, order.CustomerEmail, order.Quantity)
+	if err != nil {
+		slog.Error("worker failed to reserve seats", "order_id", order.OrderID, "event_id", order.EventID, "err", err)
+		return
+	}
+	if !reserved {
+		if err := recordTransition(order.OrderID, order.CustomerEmail, StatusPending, StatusRejectedSoldOut, "event sold out", ""); err != nil {
+			slog.Error("worker failed to record REJECTED_SOLD_OUT", "order_id", order.OrderID, "err", err)
+		}
+		return
+	}
+
+	var reservation Reservation
+	if err := db.Where("order_id = ?", order.OrderID).First(&reservation).Error; err != nil {
+		slog.Error("worker failed to load reservation", "order_id", order.OrderID, "err", err)
+		return
+	}
+
+	if err := recordTransition(order.OrderID, order.CustomerEmail, StatusPending, StatusReserved, "inventory reserved", ""); err != nil {
+		slog.Error("worker failed to record RESERVED", "order_id", order.OrderID, "err", err)
+		return
+	}
+
+	if err := recordTransition(order.OrderID, order.CustomerEmail, StatusReserved, StatusCharging, "charge started", ""); err != nil {
+		slog.Error("worker failed to record CHARGING", "order_id", order.OrderID, "err", err)
+		return
+	}
+
+	providerRef, err := chargeOrder(order)
+	if err != nil {
+		slog.Error("worker charge failed", "order_id", order.OrderID, "err", err)
+		if releaseErr := releaseReservation(reservation); releaseErr != nil {
+			slog.Error("worker failed to release reservation", "order_id", order.OrderID, "err", releaseErr)
+		}
+		if err := recordTransition(order.OrderID, order.CustomerEmail, StatusCharging, StatusPaymentFailed, err.Error(), providerRef); err != nil {
+			slog.Error("worker failed to record PAYMENT_FAILED", "order_id", order.OrderID, "err", err)
+		}
+		return
+	}
+
+	if err := confirmSale(reservation); err != nil {
+		slog.Error("worker failed to confirm sale", "order_id", order.OrderID, "err", err)
+	}
+
+	if err := recordTransition(order.OrderID, order.CustomerEmail, StatusCharging, StatusPaid, "charge succeeded", providerRef); err != nil {
+		slog.Error("worker failed to record PAID", "order_id", order.OrderID, "err", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordTransition_UpdatesStatusAndPublishes(t *testing.T) {
+	setupTestDB(t)
+
+	order := Order{OrderID: "order-1", EventID: "evt-1", Quantity: 1, CustomerEmail: "a@example.com", Status: StatusPending, CreatedAt: time.Now()}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatalf("failed to seed order: %v", err)
+	}
+
+	sub, unsubscribe := eventBus.Subscribe()
+	defer unsubscribe()
+
+	if err := recordTransition(order.OrderID, order.CustomerEmail, StatusPending, StatusReserved, "inventory reserved", ""); err != nil {
+		t.Fatalf("recordTransition returned error: %v", err)
+	}
+
+	var updated Order
+	if err := db.Where("order_id = ?", order.OrderID).First(&updated).Error; err != nil {
+		t.Fatalf("failed to reload order: %v", err)
+	}
+	if updated.Status != StatusReserved {
+		t.Fatalf("expected order status %s, got %s", StatusReserved, updated.Status)
+	}
+
+	var events []OrderEvent
+	if err := db.Where("order_id = ?", order.OrderID).Find(&events).Error; err != nil {
+		t.Fatalf("failed to load order events: %v", err)
+	}
+	if len(events) != 1 || events[0].From != StatusPending || events[0].To != StatusReserved {
+		t.Fatalf("expected a single PENDING->RESERVED event, got %+v", events)
+	}
+
+	select {
+	case published := <-sub:
+		if published.OrderID != order.OrderID || published.To != StatusReserved {
+			t.Fatalf("unexpected published event: %+v", published)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for OrderEventBus publish")
+	}
+}
+
+func TestProcessOrder_SoldOutRecordsCompensatingTransition(t *testing.T) {
+	setupTestDB(t)
+
+	insertTestEvent(t, Event{
+		EventID:      "evt-sold-out",
+		TotalSeats:   1,
+		Sold:         1, // already fully sold, no seats left for anyone
+		SaleOpensAt:  time.Now().Add(-time.Hour),
+		SaleClosesAt: time.Now().Add(time.Hour),
+	})
+
+	order := Order{OrderID: "order-sold-out", EventID: "evt-sold-out", Quantity: 1, CustomerEmail: "a@example.com", Status: StatusPending, CreatedAt: time.Now()}
+	if err := db.Create(&order).Error; err != nil {
+		t.Fatalf("failed to seed order: %v", err)
+	}
+
+	processOrder(order)
+
+	var updated Order
+	if err := db.Where("order_id = ?", order.OrderID).First(&updated).Error; err != nil {
+		t.Fatalf("failed to reload order: %v", err)
+	}
+	if updated.Status != StatusRejectedSoldOut {
+		t.Fatalf("expected order status %s, got %s", StatusRejectedSoldOut, updated.Status)
+	}
+
+	var events []OrderEvent
+	if err := db.Where(`order_id = ? AND "to" = ?`, order.OrderID, StatusRejectedSoldOut).Find(&events).Error; err != nil {
+		t.Fatalf("failed to load order events: %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("expected a single REJECTED_SOLD_OUT event, got %d", len(events))
+	}
+
+	var reservationCount int64
+	if err := db.Model(&Reservation{}).Where("order_id = ?", order.OrderID).Count(&reservationCount).Error; err != nil {
+		t.Fatalf("failed to count reservations: %v", err)
+	}
+	if reservationCount != 0 {
+		t.Fatalf("sold-out order should never get a reservation row, found %d", reservationCount)
+	}
+}
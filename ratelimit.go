@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitConfig holds the default admission limits; VIP events can
+// raise their own gate via Event.AdmissionRPSOverride.
+type RateLimitConfig struct {
+	PerCustomerRPS   float64 // per (ip, customer_email) token bucket rate
+	PerCustomerBurst int     // per (ip, customer_email) token bucket burst
+	PerEventRPS      float64 // default per-event_id leaky-bucket admission rate
+}
+
+var rateLimitConfig = RateLimitConfig{
+	PerCustomerRPS:   5,
+	PerCustomerBurst: 20,
+	PerEventRPS:      50,
+}
+
+// AdmissionLimiter is satisfied by both the in-memory and Redis-backed
+// implementations so multiple API replicas can share state behind the
+// same interface.
+type AdmissionLimiter interface {
+	// Allow reports whether a request identified by key may proceed
+	// given a sustained rate of rps and a burst of burst, and if not,
+	// how long the caller should wait before retrying.
+	Allow(ctx context.Context, key string, rps float64, burst int) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// newAdmissionLimiter selects an implementation via the RATE_LIMITER
+// env var (one of "memory", "redis"), defaulting to "memory".
+func newAdmissionLimiter() AdmissionLimiter {
+	if os.Getenv("RATE_LIMITER") == "redis" {
+		return newRedisAdmissionLimiter(os.Getenv("RATE_LIMITER_REDIS_ADDR"))
+	}
+	return newInMemoryAdmissionLimiter()
+}
+
+var (
+	customerLimiter AdmissionLimiter = newAdmissionLimiter()
+	eventLimiter    AdmissionLimiter = newAdmissionLimiter()
+)
+
+// inMemoryAdmissionLimiter keeps one golang.org/x/time/rate.Limiter per
+// key. It's only correct within a single process, which is fine for
+// local dev and single-replica deployments.
+type inMemoryAdmissionLimiter struct {
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+func newInMemoryAdmissionLimiter() *inMemoryAdmissionLimiter {
+	return &inMemoryAdmissionLimiter{limiters: make(map[string]*rate.Limiter)}
+}
+
+func (l *inMemoryAdmissionLimiter) Allow(ctx context.Context, key string, rps float64, burst int) (bool, time.Duration, error) {
+	l.mu.Lock()
+	limiter, ok := l.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(rps), burst)
+		l.limiters[key] = limiter
+	}
+	l.mu.Unlock()
+
+	reservation := limiter.Reserve()
+	if !reservation.OK() {
+		return false, 0, fmt.Errorf("rate: burst %d exceeded for key %s", burst, key)
+	}
+	delay := reservation.Delay()
+	if delay > 0 {
+		reservation.Cancel()
+		return false, delay, nil
+	}
+	return true, 0, nil
+}
+
+// redisAdmissionLimiter implements a genuine sliding window per key,
+// using a sorted set of request timestamps: each Allow call trims
+// entries older than the window, counts what's left, and only adds
+// itself if under budget. That avoids the fixed-window bug where a
+// burst straddling a window boundary (e.g. the last instant of one
+// second and the first instant of the next) can admit 2x the configured
+// rate.
+type redisAdmissionLimiter struct {
+	client *redis.Client
+}
+
+func newRedisAdmissionLimiter(addr string) *redisAdmissionLimiter {
+	return &redisAdmissionLimiter{client: redis.NewClient(&redis.Options{Addr: addr})}
+}
+
+// slidingWindowScript atomically trims the sorted set to the current
+// window, counts the remaining entries, and adds the new request only if
+// that count is still under the limit, so two concurrent callers can
+// never both observe room and both admit.
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now_ms = tonumber(ARGV[1])
+local window_ms = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local member = ARGV[4]
+
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now_ms - window_ms)
+local count = redis.call("ZCARD", key)
+if count >= limit then
+	local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+	local retry_after_ms = window_ms
+	if oldest[2] ~= nil then
+		retry_after_ms = tonumber(oldest[2]) + window_ms - now_ms
+	end
+	return retry_after_ms
+end
+
+redis.call("ZADD", key, now_ms, member)
+redis.call("PEXPIRE", key, window_ms)
+return 0
+`)
+
+func (l *redisAdmissionLimiter) Allow(ctx context.Context, key string, rps float64, burst int) (bool, time.Duration, error) {
+	redisKey := fmt.Sprintf("ratelimit:%s", key)
+	// A window of burst/rps seconds holding at most burst requests lets a
+	// key burst up to burst at once while averaging rps over the window.
+	limit := int64(burst)
+	if limit < 1 {
+		limit = 1
+	}
+	windowMs := int64(1000)
+	if rps > 0 {
+		windowMs = max(1, int64(float64(limit)/rps*1000))
+	}
+	member := fmt.Sprintf("%d-%s", time.Now().UnixNano(), key)
+
+	retryAfterMs, err := slidingWindowScript.Run(
+		ctx, l.client, []string{redisKey}, time.Now().UnixMilli(), windowMs, limit, member,
+	).Int64()
+	if err != nil {
+		return false, 0, err
+	}
+	if retryAfterMs > 0 {
+		return false, time.Duration(retryAfterMs) * time.Millisecond, nil
+	}
+	return true, 0, nil
+}
+
+// peekJSONField re-reads r.Body to extract a single top-level string
+// field, then restores the body so downstream decoding still works.
+func peekJSONField(r *http.Request, field string) (string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", nil // malformed body; let the real decoder produce the 400
+	}
+	value, _ := fields[field].(string)
+	return value, nil
+}
+
+// clientIP extracts the caller's address for the per-customer bucket
+// key, preferring X-Forwarded-For since this typically sits behind a
+// load balancer.
+func clientIP(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return forwarded
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// eventAdmissionRPS returns the event's override rate if one is set,
+// otherwise the global default.
+func eventAdmissionRPS(eventID string) float64 {
+	if eventID == "" {
+		return rateLimitConfig.PerEventRPS
+	}
+	var event Event
+	if err := db.Where("event_id = ?", eventID).First(&event).Error; err != nil {
+		return rateLimitConfig.PerEventRPS
+	}
+	if event.AdmissionRPSOverride > 0 {
+		return event.AdmissionRPSOverride
+	}
+	return rateLimitConfig.PerEventRPS
+}
+
+// rateLimitMiddleware enforces two admission gates ahead of
+// handleCreateOrder: a per (ip, customer_email) token bucket, and a
+// per-event_id leaky bucket so a flash-sale burst never floods
+// inventory/payment faster than the event's configured admission rate.
+func rateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		customerEmail, err := peekJSONField(r, "customer_email")
+		if err != nil {
+			slog.Error("rate limit failed to read request body", "err", err)
+			http.Error(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+		eventID, err := peekJSONField(r, "event_id")
+		if err != nil {
+			slog.Error("rate limit failed to read request body", "err", err)
+			http.Error(w, "invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		customerKey := fmt.Sprintf("customer:%s:%s", clientIP(r), customerEmail)
+		allowed, retryAfter, err := customerLimiter.Allow(r.Context(), customerKey, rateLimitConfig.PerCustomerRPS, rateLimitConfig.PerCustomerBurst)
+		if err != nil {
+			slog.Error("rate limit customer bucket error", "customer_email", customerEmail, "err", err)
+		} else if !allowed {
+			respondRateLimited(w, retryAfter)
+			return
+		}
+
+		eventKey := fmt.Sprintf("event:%s", eventID)
+		rps := eventAdmissionRPS(eventID)
+		allowed, retryAfter, err = eventLimiter.Allow(r.Context(), eventKey, rps, int(rps))
+		if err != nil {
+			slog.Error("rate limit event bucket error", "event_id", eventID, "err", err)
+		} else if !allowed {
+			respondRateLimited(w, retryAfter)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func respondRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(retryAfter.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondJSON(w, http.StatusTooManyRequests, APIError{"rate_limited", "too many requests, slow down"})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB points the package-level db at a fresh in-memory SQLite
+// database scoped to t's name, migrated with every model the handlers
+// under test touch, and closed on test cleanup so fixture rows from one
+// test (e.g. a shared "evt-1" EventID) never leak into the next via the
+// shared-cache in-memory database outliving the test that created it.
+// Concurrent writers under SQLite's shared-cache mode can fail with
+// SQLITE_LOCKED, which _busy_timeout does not retry (it only covers
+// SQLITE_BUSY); the pool is capped at one open connection so Go itself
+// serializes them instead, which is what the atomicity tests below
+// actually rely on.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared&_busy_timeout=5000", t.Name())
+	testDB, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	sqlDB, err := testDB.DB()
+	if err != nil {
+		t.Fatalf("failed to get underlying sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := testDB.AutoMigrate(&Order{}, &IdempotencyRecord{}, &OrderEvent{}, &OutboxEvent{}, &Event{}, &Reservation{}); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+	db = testDB
+}
+
+// insertTestEvent creates the given Event row as-is (callers set its sale
+// window) so validateEventAvailability and reserveSeats have something to
+// check against.
+func insertTestEvent(t *testing.T, event Event) {
+	t.Helper()
+	if err := db.Create(&event).Error; err != nil {
+		t.Fatalf("failed to insert test event %s: %v", event.EventID, err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	// Ticket buyers hit this from arbitrary front-end origins, so we
+	// don't gate on same-origin here. KNOWN GAP: customerEmailFromBearerToken
+	// (events_stream.go) doesn't actually verify the bearer token, so right
+	// now neither this nor that check stops one customer from reading
+	// another's order stream — see the doc comment there before relying
+	// on this for anything but local dev.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// handleOrdersWebSocket streams every status transition for the orders
+// belonging to the caller's customer_email (derived from the bearer
+// token) over a single WebSocket connection.
+func handleOrdersWebSocket(w http.ResponseWriter, r *http.Request) {
+	customerEmail, err := customerEmailFromBearerToken(r)
+	if err != nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("websocket upgrade failed", "customer_email", customerEmail, "err", err)
+		return
+	}
+	defer conn.Close()
+
+	sub, unsubscribe := eventBus.Subscribe()
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-sub:
+			if !ok {
+				return
+			}
+			if event.CustomerEmail != customerEmail {
+				continue
+			}
+			if err := conn.WriteJSON(event); err != nil {
+				slog.Error("websocket write failed", "customer_email", customerEmail, "err", err)
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}